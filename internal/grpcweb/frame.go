@@ -0,0 +1,70 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	// frameHeaderLen is the size of a gRPC length-prefixed frame header: a 1-byte flag followed by a 4-byte
+	// big-endian payload length.
+	frameHeaderLen = 5
+	// trailerFrameFlag marks a frame as carrying gRPC-Web trailers rather than a message payload, per the
+	// grpc-web wire format.
+	trailerFrameFlag = 0x80
+)
+
+// frameReassembler reconstructs gRPC length-prefixed frames out of a sequence of arbitrarily-chunked byte slices,
+// such as those passed to successive calls of `http.ResponseWriter.Write`.
+type frameReassembler struct {
+	buf bytes.Buffer
+}
+
+// feed appends data to the reassembler and invokes onFrame for every frame that is now complete, in order. Any
+// trailing bytes that do not yet form a complete frame are retained for the next call to feed.
+func (r *frameReassembler) feed(data []byte, onFrame func(flag byte, payload []byte) error) error {
+	r.buf.Write(data)
+	for {
+		b := r.buf.Bytes()
+		if len(b) < frameHeaderLen {
+			return nil
+		}
+		length := binary.BigEndian.Uint32(b[1:frameHeaderLen])
+		if uint64(len(b)-frameHeaderLen) < uint64(length) {
+			return nil
+		}
+		payload := append([]byte(nil), b[frameHeaderLen:frameHeaderLen+int(length)]...)
+		flag := b[0]
+		r.buf.Next(frameHeaderLen + int(length))
+		if err := onFrame(flag, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFrame appends a gRPC length-prefixed frame with the given flag and payload to buf.
+func writeFrame(buf *bytes.Buffer, flag byte, payload []byte) {
+	var header [frameHeaderLen]byte
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+// splitTrailerLines splits the HTTP/1.1-style "key: value" CRLF block carried by a gRPC-Web trailer frame into its
+// individual lines.
+func splitTrailerLines(payload []byte) []string {
+	return strings.Split(strings.TrimRight(string(payload), "\r\n"), "\r\n")
+}
+
+// cutTrailerLine splits a single "key: value" trailer line, lower-casing the key for case-insensitive lookup.
+func cutTrailerLine(line string) (key, value string, ok bool) {
+	idx := strings.IndexByte(line, ':')
+	if idx < 0 {
+		return "", "", false
+	}
+	key = strings.ToLower(strings.TrimSpace(line[:idx]))
+	value = strings.TrimSpace(line[idx+1:])
+	return key, value, key != ""
+}
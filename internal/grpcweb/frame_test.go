@@ -0,0 +1,118 @@
+package grpcweb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFrameReassembler(t *testing.T) {
+	type gotFrame struct {
+		flag    byte
+		payload []byte
+	}
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   []gotFrame
+	}{
+		{
+			name: "single frame in one chunk",
+			chunks: [][]byte{
+				frameBytes(0, []byte("hello")),
+			},
+			want: []gotFrame{{0, []byte("hello")}},
+		},
+		{
+			name: "frame split across multiple chunks",
+			chunks: func() [][]byte {
+				full := frameBytes(0, []byte("hello world"))
+				return [][]byte{full[:3], full[3:9], full[9:]}
+			}(),
+			want: []gotFrame{{0, []byte("hello world")}},
+		},
+		{
+			name: "multiple frames in one chunk",
+			chunks: [][]byte{
+				append(frameBytes(0, []byte("first")), frameBytes(trailerFrameFlag, []byte("second"))...),
+			},
+			want: []gotFrame{
+				{0, []byte("first")},
+				{trailerFrameFlag, []byte("second")},
+			},
+		},
+		{
+			name: "empty payload frame",
+			chunks: [][]byte{
+				frameBytes(0, nil),
+			},
+			want: []gotFrame{{0, []byte{}}},
+		},
+		{
+			name: "trailing partial frame header retained",
+			chunks: [][]byte{
+				frameBytes(0, []byte("a")),
+				{0x00, 0x00},
+			},
+			want: []gotFrame{{0, []byte("a")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r frameReassembler
+			var got []gotFrame
+			for _, chunk := range tt.chunks {
+				if err := r.feed(chunk, func(flag byte, payload []byte) error {
+					got = append(got, gotFrame{flag, append([]byte(nil), payload...)})
+					return nil
+				}); err != nil {
+					t.Fatalf("feed: %v", err)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, g := range got {
+				if g.flag != tt.want[i].flag || !bytes.Equal(g.payload, tt.want[i].payload) {
+					t.Errorf("frame %d = %+v, want %+v", i, g, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFrameReassemblerPropagatesOnFrameError(t *testing.T) {
+	var r frameReassembler
+	wantErr := errors.New("boom")
+	err := r.feed(frameBytes(0, []byte("x")), func(flag byte, payload []byte) error {
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("feed() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestSplitAndCutTrailerLine(t *testing.T) {
+	payload := []byte("grpc-status: 5\r\ngrpc-message: not found\r\n")
+	lines := splitTrailerLines(payload)
+	if len(lines) != 2 {
+		t.Fatalf("splitTrailerLines() = %v, want 2 lines", lines)
+	}
+
+	key, value, ok := cutTrailerLine(lines[0])
+	if !ok || key != "grpc-status" || value != "5" {
+		t.Errorf("cutTrailerLine(%q) = %q, %q, %v", lines[0], key, value, ok)
+	}
+
+	if _, _, ok := cutTrailerLine("no-colon-here"); ok {
+		t.Errorf("cutTrailerLine() on malformed line should return ok=false")
+	}
+}
+
+func frameBytes(flag byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	writeFrame(&buf, flag, payload)
+	return buf.Bytes()
+}
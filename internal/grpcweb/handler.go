@@ -0,0 +1,185 @@
+package grpcweb
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcMetadataPrefix is the convention used by this package's underlying HTTP/2 bridge to surface gRPC response
+// metadata (set via `grpc.SetHeader`/`grpc.SetTrailer`) as plain HTTP response headers.
+const grpcMetadataPrefix = "Grpc-Metadata-"
+
+// hopByHopHeaders lists the headers that must never be forwarded across a proxying boundary, per RFC 7230 §6.1.
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// defaultHeaderFilter allows every incoming header to be copied into gRPC metadata, except hop-by-hop headers and
+// ones already reserved by this package's own `Grpc-*` conventions.
+func defaultHeaderFilter(name string) bool {
+	canonical := http.CanonicalHeaderKey(name)
+	if hopByHopHeaders[canonical] {
+		return false
+	}
+	return !strings.HasPrefix(canonical, "Grpc-")
+}
+
+// HandlerOption configures JSONHandler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	writerOpts   []Option
+	headerFilter func(name string) bool
+}
+
+// WithWriterOptions passes through Options (e.g. WithStreamFormat) used to construct the jsonWriter for each
+// request. They take precedence over the format negotiated from the request's `Accept` header.
+func WithWriterOptions(opts ...Option) HandlerOption {
+	return func(o *handlerOptions) {
+		o.writerOpts = append(o.writerOpts, opts...)
+	}
+}
+
+// WithHeaderFilter overrides which incoming HTTP headers are copied into gRPC metadata. The default allows every
+// header that is neither hop-by-hop nor one of this package's own `Grpc-*` headers.
+func WithHeaderFilter(allow func(name string) bool) HandlerOption {
+	return func(o *handlerOptions) {
+		o.headerFilter = allow
+	}
+}
+
+// JSONHandler wraps grpcServer so it can be invoked directly as a plain http.Handler for JSON/gRPC-Web transcoded
+// requests. Before dispatching to grpcServer, it:
+//   - negotiates the response StreamFormat from the request's `Accept` header (see NegotiateFormat);
+//   - parses a `Grpc-Timeout` header into a context deadline;
+//   - copies allow-listed HTTP headers into gRPC metadata, readable via `metadata.FromIncomingContext`;
+//   - promotes response headers prefixed `Grpc-Metadata-` back to plain HTTP headers, with the prefix stripped;
+//   - ensures the trailer is still flushed if the handler panics, the request is canceled, or its deadline expires.
+func JSONHandler(grpcServer *grpc.Server, opts ...HandlerOption) http.Handler {
+	o := handlerOptions{headerFilter: defaultHeaderFilter}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout, ok := parseGrpcTimeout(r.Header.Get("Grpc-Timeout")); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		ctx = metadata.NewIncomingContext(ctx, headersToMetadata(r.Header, o.headerFilter))
+		r = r.WithContext(ctx)
+
+		format, contentType := NegotiateFormat(r.Header.Get("Accept"))
+		writerOpts := append([]Option{WithStreamFormat(format), WithContentType(contentType)}, o.writerOpts...)
+		rw, finalize := NewJsonStreamWriter(metadataPromotingWriter{w}, writerOpts...)
+
+		var once sync.Once
+		finalizeOnce := func() error {
+			var err error
+			once.Do(func() { err = finalize() })
+			return err
+		}
+		// Guarantee the trailer/JSON error body is still emitted if the client disconnects or the deadline
+		// installed above expires while grpcServer is still writing to rw.
+		stop := context.AfterFunc(ctx, func() { _ = finalizeOnce() })
+		defer stop()
+
+		defer func() {
+			if p := recover(); p != nil {
+				_ = finalizeOnce()
+				panic(p)
+			}
+		}()
+
+		grpcServer.ServeHTTP(rw, r)
+		_ = finalizeOnce()
+	})
+}
+
+// parseGrpcTimeout parses the value of a `Grpc-Timeout` header, formatted as `<positive-integer><unit>` where unit
+// is one of H(ours), M(inutes), S(econds), m(illiseconds), u(microseconds) or n(anoseconds).
+func parseGrpcTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// headersToMetadata copies the headers of hdr allowed by allow into gRPC metadata, lower-casing keys as required
+// by the metadata package.
+func headersToMetadata(hdr http.Header, allow func(name string) bool) metadata.MD {
+	md := make(metadata.MD, len(hdr))
+	for key, values := range hdr {
+		if !allow(key) {
+			continue
+		}
+		md.Append(strings.ToLower(key), values...)
+	}
+	return md
+}
+
+// metadataPromotingWriter rewrites any `Grpc-Metadata-`-prefixed header to its unprefixed form right before headers
+// are sent, so that gRPC response metadata set by a handler (e.g. via `grpc.SetHeader`) surfaces as plain HTTP
+// headers to JSON/gRPC-Web clients.
+type metadataPromotingWriter struct {
+	http.ResponseWriter
+}
+
+func (w metadataPromotingWriter) WriteHeader(statusCode int) {
+	hdr := w.ResponseWriter.Header()
+	for key, values := range hdr {
+		if !strings.HasPrefix(key, grpcMetadataPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, grpcMetadataPrefix)
+		hdr.Del(key)
+		for _, value := range values {
+			hdr.Add(name, value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does.
+func (w metadataPromotingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
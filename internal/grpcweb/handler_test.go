@@ -0,0 +1,98 @@
+package grpcweb
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseGrpcTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantOK     bool
+		wantResult time.Duration
+	}{
+		{name: "seconds", value: "10S", wantOK: true, wantResult: 10 * time.Second},
+		{name: "hours", value: "2H", wantOK: true, wantResult: 2 * time.Hour},
+		{name: "minutes", value: "5M", wantOK: true, wantResult: 5 * time.Minute},
+		{name: "milliseconds", value: "250m", wantOK: true, wantResult: 250 * time.Millisecond},
+		{name: "microseconds", value: "100u", wantOK: true, wantResult: 100 * time.Microsecond},
+		{name: "nanoseconds", value: "100n", wantOK: true, wantResult: 100 * time.Nanosecond},
+		{name: "empty", value: "", wantOK: false},
+		{name: "unit only", value: "S", wantOK: false},
+		{name: "zero", value: "0S", wantOK: false},
+		{name: "negative", value: "-1S", wantOK: false},
+		{name: "non-numeric", value: "abcS", wantOK: false},
+		{name: "unknown unit", value: "10X", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseGrpcTimeout(tt.value)
+			if ok != tt.wantOK {
+				t.Fatalf("parseGrpcTimeout(%q) ok = %v, want %v", tt.value, ok, tt.wantOK)
+			}
+			if ok && got != tt.wantResult {
+				t.Errorf("parseGrpcTimeout(%q) = %v, want %v", tt.value, got, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestHeadersToMetadata(t *testing.T) {
+	hdr := http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Request-Id":  {"abc", "def"},
+		"Connection":    {"keep-alive"},
+		"Grpc-Timeout":  {"10S"},
+	}
+
+	md := headersToMetadata(hdr, defaultHeaderFilter)
+
+	want := map[string][]string{
+		"authorization": {"Bearer token"},
+		"x-request-id":  {"abc", "def"},
+	}
+	if !reflect.DeepEqual(map[string][]string(md), want) {
+		t.Errorf("headersToMetadata() = %v, want %v", md, want)
+	}
+}
+
+func TestHeadersToMetadataCustomFilter(t *testing.T) {
+	hdr := http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Internal":    {"secret"},
+	}
+
+	md := headersToMetadata(hdr, func(name string) bool {
+		return name != "X-Internal"
+	})
+
+	if _, ok := md["x-internal"]; ok {
+		t.Errorf("headersToMetadata() should have dropped X-Internal, got %v", md)
+	}
+	if values := md.Get("authorization"); len(values) != 1 || values[0] != "Bearer token" {
+		t.Errorf("headersToMetadata() authorization = %v, want [Bearer token]", values)
+	}
+}
+
+func TestDefaultHeaderFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Authorization", true},
+		{"X-Request-Id", true},
+		{"Connection", false},
+		{"Transfer-Encoding", false},
+		{"Grpc-Timeout", false},
+		{"Grpc-Metadata-Foo", false},
+	}
+	for _, tt := range tests {
+		if got := defaultHeaderFilter(tt.name); got != tt.want {
+			t.Errorf("defaultHeaderFilter(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,38 @@
 package grpcweb
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/binary"
 	"io"
 	"io/ioutil"
 )
 
+// maxJSONLineSize bounds the size of a single newline-delimited JSON record read from a request body.
+const maxJSONLineSize = 16 * 1024 * 1024
+
+// jsonSeqRecordSeparator is the RFC 7464 record separator that may prefix each line of a JSON text sequence body.
+var jsonSeqRecordSeparator = []byte{0x1e}
+
+// NewJsonReader returns a reader that transcodes a newline-delimited JSON (or JSON text sequence) request body into
+// a stream of gRPC length-prefixed frames, suitable for being read by a `grpc.Server`.
 func NewJsonReader(body io.ReadCloser) (io.ReadCloser, error) {
-	content, err := ioutil.ReadAll(body)
-	if err != nil {
+	defer body.Close()
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimPrefix(scanner.Bytes(), jsonSeqRecordSeparator)
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		writeFrame(&out, 0, line)
+	}
+	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	defer body.Close()
-	newBody := make([]byte, len(content)+5)
-	binary.BigEndian.PutUint32(newBody[1:], uint32(len(content)))
-	copy(newBody[5:], content)
-	return &jsonReader{ioutil.NopCloser(bytes.NewBuffer(newBody))}, nil
+	return &jsonReader{ioutil.NopCloser(&out)}, nil
 }
 
 type jsonReader struct {
@@ -1,146 +1,525 @@
-// Copyright (c) 2020 StackRox Inc.
-//
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-//     http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License
-
 package grpcweb
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/longXboy/go-grpc-http1/internal/sliceutils"
 
 	"google.golang.org/grpc/codes"
 )
 
+// StreamFormat selects how a (potentially server-streaming) gRPC response is rendered for the client.
+type StreamFormat int
+
+const (
+	// FormatNDJSON renders every message as a standalone JSON object followed by a newline, so a client can parse
+	// the response incrementally, line by line (newline-delimited JSON). This is the default.
+	FormatNDJSON StreamFormat = iota
+	// FormatJSONSeq renders every message per RFC 7464 (JSON text sequences): a record separator (0x1E) followed
+	// by the JSON object and a trailing newline.
+	FormatJSONSeq
+	// FormatSSE renders every message as a Server-Sent Event (`event: message`), with a final `event: trailer`
+	// event carrying the gRPC status once the response completes.
+	FormatSSE
+	// FormatGRPCWeb renders the response per the gRPC-Web wire format (`application/grpc-web`,
+	// `application/grpc-web+json`): each message is a length-prefixed frame, followed by a trailer frame (flag
+	// 0x80) carrying the gRPC status and any other announced trailers as an HTTP/1.1-style header block.
+	FormatGRPCWeb
+	// FormatGRPCWebText is FormatGRPCWeb with the concatenated message and trailer frames base64-encoded, as
+	// required by browser clients (`application/grpc-web-text`) that cannot read binary responses.
+	FormatGRPCWebText
+	// FormatJSONLegacy reproduces the original, pre-streaming behavior: the whole response is buffered and sent
+	// as a single JSON body, with the gRPC status mapped to an HTTP status code via fromGrpcToStatus. It only
+	// makes sense for unary RPCs and must be requested explicitly.
+	FormatJSONLegacy
+)
+
+// Option configures a jsonWriter constructed via NewJsonStreamWriter.
+type Option func(*writerOptions)
+
+type writerOptions struct {
+	format      StreamFormat
+	contentType string
+}
+
+// WithStreamFormat selects the wire format used to render the response. The default, used if this option is not
+// given, is FormatNDJSON.
+func WithStreamFormat(format StreamFormat) Option {
+	return func(o *writerOptions) {
+		o.format = format
+	}
+}
+
+// WithContentType overrides the `Content-Type` response header that would otherwise be derived from the
+// StreamFormat. JSONHandler uses it to pass on the content type returned by NegotiateFormat, preserving the
+// client's exact requested media type (e.g. `application/grpc-web+json` as opposed to `application/grpc-web`) even
+// though both negotiate to FormatGRPCWeb.
+func WithContentType(contentType string) Option {
+	return func(o *writerOptions) {
+		o.contentType = contentType
+	}
+}
+
+// NegotiateFormat selects a StreamFormat and response content type from the value of a request's `Accept` header.
+// It falls back to FormatNDJSON if the header is empty or does not match any supported media type.
+func NegotiateFormat(acceptHeader string) (StreamFormat, string) {
+	for _, accept := range parseAccept(acceptHeader) {
+		switch accept {
+		case "application/grpc-web-text":
+			return FormatGRPCWebText, accept
+		case "application/grpc-web", "application/grpc-web+json", "application/grpc-web+proto":
+			return FormatGRPCWeb, accept
+		case "application/json":
+			return FormatJSONLegacy, accept
+		case "application/json-seq":
+			return FormatJSONSeq, accept
+		case "text/event-stream":
+			return FormatSSE, accept
+		case "application/x-ndjson":
+			return FormatNDJSON, accept
+		}
+	}
+	return FormatNDJSON, contentTypeForFormat(FormatNDJSON)
+}
+
+// parseAccept splits an `Accept` header into its constituent media types, stripping parameters (such as `q` values)
+// and preserving the client's preference order.
+func parseAccept(header string) []string {
+	parts := strings.Split(header, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if idx := strings.IndexByte(part, ';'); idx >= 0 {
+			part = part[:idx]
+		}
+		if part != "" {
+			out = append(out, strings.ToLower(strings.TrimSpace(part)))
+		}
+	}
+	return out
+}
+
 type jsonWriter struct {
-	w http.ResponseWriter
+	// mu guards every field below against the data race between the goroutine running grpcServer.ServeHTTP (which
+	// owns Write/WriteHeader/Flush) and a JSONHandler installed via context.AfterFunc calling Finalize from a
+	// second goroutine once the request context is canceled or its deadline expires.
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	opts    writerOptions
 
-	statusCode int
-	header     []byte
-	body       *bytes.Buffer
-	// List of trailers that were announced via the `Trailer` header at the time headers were written. Also used to keep
-	// track of whether headers were already written (in which case this is non-nil, even if it is the empty slice).
+	headersWritten    bool
+	statusCode        int
 	announcedTrailers []string
+
+	// finalized is set once Finalize has sent the trailer frame/record, so that a Write/WriteHeader call racing in
+	// from grpcServer.ServeHTTP on another goroutine (e.g. arriving just as JSONHandler's context.AfterFunc fires
+	// Finalize on cancellation) can no longer emit a message after the stream has already been terminated.
+	finalized bool
+
+	reassembler frameReassembler
+
+	trailerStatus  codes.Code
+	trailerMessage string
+
+	// legacyBody buffers the response for FormatJSONLegacy, which only learns the final HTTP status at Finalize
+	// time.
+	legacyBody *bytes.Buffer
+
+	// b64Pending holds the 0-2 bytes left over from the last FormatGRPCWebText write that didn't divide evenly
+	// into a 3-byte base64 group, so that each Write call still emits valid, self-contained base64.
+	b64Pending []byte
 }
 
-// NewJsonWriter returns a response writer that transparently transcodes an gRPC HTTP/2 response to a gRPC-Web
-// response. It can be used as the response writer in the `ServeHTTP` method of a `grpc.Server`.
-// The second return value is a finalization function that takes care of sending the data frame with trailers. It
-// *needs* to be called before the response handler exits successfully (the returned error is simply any error of the
+// NewJsonWriter returns a response writer that transparently transcodes a gRPC HTTP/2 response to a
+// newline-delimited JSON response, flushing after every message so that server-streaming responses can be consumed
+// incrementally. It can be used as the response writer in the `ServeHTTP` method of a `grpc.Server`.
+// The second return value is a finalization function that takes care of sending the trailer record. It *needs* to
+// be called before the response handler exits successfully (the returned error is simply any error of the
 // underlying response writer passed through).
 func NewJsonWriter(w http.ResponseWriter) (http.ResponseWriter, func() error) {
+	return NewJsonStreamWriter(w)
+}
+
+// NewJsonStreamWriter is a variant of NewJsonWriter that accepts Options, in particular WithStreamFormat, to
+// control how the response is rendered.
+func NewJsonStreamWriter(w http.ResponseWriter, opts ...Option) (http.ResponseWriter, func() error) {
+	o := writerOptions{format: FormatNDJSON}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	flusher, _ := w.(http.Flusher)
 	rw := &jsonWriter{
-		w:    w,
-		body: bytes.NewBuffer(nil),
+		w:             w,
+		flusher:       flusher,
+		opts:          o,
+		trailerStatus: codes.OK,
 	}
 	return rw, rw.Finalize
 }
 
 // Header returns the HTTP Header of the underlying response writer.
 func (w *jsonWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.w.Header()
 }
 
-// Flush flushes any data not yet written. In contrast to most `http.ResponseWriter` implementations, it does not send
-// headers if no data has been written yet.
+// Flush flushes any data not yet written. In contrast to most `http.ResponseWriter` implementations, it does not
+// send headers if no data has been written yet. With FormatJSONLegacy, it does nothing until Finalize has run.
 func (w *jsonWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.headersWritten || (w.opts.format == FormatJSONLegacy && !w.finalized) {
+		return
+	}
+	w.flushLocked()
+}
+
+// flushLocked is the body of Flush, factored out so internal callers that already hold mu (i.e. everything below
+// Write/Finalize) don't re-enter the lock.
+func (w *jsonWriter) flushLocked() {
+	if w.flusher != nil {
+		w.flusher.Flush()
+	}
 }
 
 // prepareHeadersIfNecessary is called internally on any action that might cause headers to be sent.
 func (w *jsonWriter) prepareHeadersIfNecessary() {
-	if w.announcedTrailers != nil {
+	if w.headersWritten {
 		return
 	}
+	w.headersWritten = true
 
 	hdr := w.w.Header()
 	w.announcedTrailers = sliceutils.StringClone(hdr["Trailer"])
-	// Trailers are sent in a data frame, so don't announce trailers as otherwise downstream proxies might get confused.
+	// Trailers are rendered as a trailer frame or a final record in the body, so don't announce them via the
+	// `Trailer` header, or downstream proxies might get confused.
 	hdr.Del("Trailer")
 
-	hdr.Set("Content-Type", "application/json")
+	ct := w.opts.contentType
+	if ct == "" {
+		ct = contentTypeForFormat(w.opts.format)
+	}
+	hdr.Set("Content-Type", ct)
+
+	if w.opts.format == FormatJSONLegacy {
+		// The legacy format buffers the whole response and only knows the final HTTP status once Finalize is
+		// called, so defer sending headers until then.
+		w.legacyBody = bytes.NewBuffer(nil)
+		return
+	}
 
-	// Any content length that might be set is no longer accurate because of trailers.
-	//hdr.Del("Content-Length")
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.w.WriteHeader(w.statusCode)
 }
 
 // WriteHeader sends HTTP headers to the client, along with the given status code.
 func (w *jsonWriter) WriteHeader(statusCode int) {
-	w.prepareHeadersIfNecessary()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.finalized {
+		return
+	}
 	w.statusCode = statusCode
 }
 
-// Write writes a chunk of data.
+// Write accepts a chunk of the raw gRPC length-prefixed stream as produced by a `grpc.Server`, reassembling it into
+// complete frames and rendering each message frame per the configured StreamFormat. Frames flagged as gRPC-Web
+// trailer frames are not rendered as messages; their status and message are recorded for Finalize. Once Finalize
+// has run (e.g. triggered early by JSONHandler on cancellation), Write is a no-op: the trailer has already been
+// sent, so writing a further message would corrupt the framing the client has already consumed as end-of-stream.
 func (w *jsonWriter) Write(buf []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.finalized {
+		return 0, http.ErrHandlerTimeout
+	}
 	w.prepareHeadersIfNecessary()
 
-	return w.body.Write(buf)
+	err := w.reassembler.feed(buf, func(flag byte, payload []byte) error {
+		if flag&trailerFrameFlag != 0 {
+			w.trailerStatus, w.trailerMessage = parseTrailerFrame(payload)
+			return nil
+		}
+		return w.writeMessage(payload)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (w *jsonWriter) writeMessage(payload []byte) error {
+	switch w.opts.format {
+	case FormatGRPCWeb, FormatGRPCWebText:
+		return w.writeFramed(0, payload)
+	case FormatJSONLegacy:
+		w.legacyBody.Write(payload)
+		return nil
+	case FormatSSE:
+		return w.writeAndFlush("event: message\ndata: %s\n\n", payload)
+	case FormatJSONSeq:
+		return w.writeAndFlush("\x1e%s\n", payload)
+	default: // FormatNDJSON
+		return w.writeAndFlush("%s\n", payload)
+	}
+}
+
+func (w *jsonWriter) writeAndFlush(format string, payload []byte) error {
+	if _, err := fmt.Fprintf(w.w, format, payload); err != nil {
+		return err
+	}
+	w.flushLocked()
+	return nil
+}
+
+// writeFramed emits payload as a single gRPC length-prefixed frame with the given flag, base64-encoding it first
+// if the negotiated format is FormatGRPCWebText.
+func (w *jsonWriter) writeFramed(flag byte, payload []byte) error {
+	var buf bytes.Buffer
+	writeFrame(&buf, flag, payload)
+	if w.opts.format == FormatGRPCWebText {
+		return w.writeBase64(buf.Bytes())
+	}
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.flushLocked()
+	return nil
+}
+
+// writeBase64 base64-encodes data onto the response, carrying over any bytes that don't divide evenly into a
+// 3-byte group so that every write remains valid, decodable base64 without relying on a final padding write.
+func (w *jsonWriter) writeBase64(data []byte) error {
+	pending := append(w.b64Pending, data...)
+	n := len(pending) - len(pending)%3
+	enc := make([]byte, base64.StdEncoding.EncodedLen(n))
+	base64.StdEncoding.Encode(enc, pending[:n])
+	if _, err := w.w.Write(enc); err != nil {
+		return err
+	}
+	w.b64Pending = append([]byte(nil), pending[n:]...)
+	w.flushLocked()
+	return nil
+}
+
+func (w *jsonWriter) flushBase64Trailer() error {
+	if len(w.b64Pending) == 0 {
+		return nil
+	}
+	enc := make([]byte, base64.StdEncoding.EncodedLen(len(w.b64Pending)))
+	base64.StdEncoding.Encode(enc, w.b64Pending)
+	_, err := w.w.Write(enc)
+	w.b64Pending = nil
+	return err
 }
 
-// Finalize sends trailer data in a data frame. It *needs* to be called
+// Finalize sends the trailer frame or record. It *needs* to be called once the handler has finished writing to the
+// stream. It is idempotent: a second call, including one racing in from another goroutine, is a no-op.
 func (w *jsonWriter) Finalize() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.finalized {
+		return nil
+	}
+	w.finalized = true
 	w.prepareHeadersIfNecessary()
-	var body []byte
-	if w.body.Len() >= 5 {
-		body = w.body.Bytes()[5:]
-	} else {
-		body = w.body.Bytes()
+
+	switch w.opts.format {
+	case FormatJSONLegacy:
+		return w.finalizeLegacy()
+	case FormatGRPCWeb, FormatGRPCWebText:
+		return w.finalizeGRPCWeb()
+	default:
+		return w.finalizeJSONStream()
+	}
+}
+
+// resolveTrailerStatus returns the gRPC status/message observed from an incoming trailer frame, falling back to
+// the `Grpc-Status`/`Grpc-Message` headers for transports that promote trailers to headers instead.
+func (w *jsonWriter) resolveTrailerStatus() (codes.Code, string) {
+	if w.trailerStatus != codes.OK || w.trailerMessage != "" {
+		return w.trailerStatus, w.trailerMessage
+	}
+	status := codes.OK
+	if code := new(codes.Code); code.UnmarshalJSON([]byte(w.w.Header().Get("Grpc-Status"))) == nil {
+		status = *code
+	}
+	return status, w.w.Header().Get("Grpc-Message")
+}
+
+func (w *jsonWriter) finalizeJSONStream() error {
+	status, message := w.resolveTrailerStatus()
+	trailer := fmt.Sprintf(`{"grpc-status":%d,"grpc-message":%q}`, status, message)
+
+	var err error
+	switch w.opts.format {
+	case FormatSSE:
+		_, err = fmt.Fprintf(w.w, "event: trailer\ndata: %s\n\n", trailer)
+	case FormatJSONSeq:
+		_, err = fmt.Fprintf(w.w, "\x1e%s\n", trailer)
+	default: // FormatNDJSON
+		_, err = fmt.Fprintf(w.w, "%s\n", trailer)
 	}
-	w.w.Header().Set("Content-Length", strconv.FormatInt(int64(len(body)), 10))
-	hdr := w.Header()
+	if err != nil {
+		return err
+	}
+	w.flushLocked()
+	return nil
+}
+
+func (w *jsonWriter) finalizeGRPCWeb() error {
+	status, message := w.resolveTrailerStatus()
+
+	trailers := collectTrailers(w.w.Header(), w.announcedTrailers)
+	trailers.Set("Grpc-Status", strconv.Itoa(int(status)))
+	if message != "" {
+		trailers.Set("Grpc-Message", message)
+	}
+
+	if err := w.writeFramed(trailerFrameFlag, encodeTrailerBlock(trailers)); err != nil {
+		return err
+	}
+	if w.opts.format == FormatGRPCWebText {
+		if err := w.flushBase64Trailer(); err != nil {
+			return err
+		}
+	}
+	w.flushLocked()
+	return nil
+}
+
+func (w *jsonWriter) finalizeLegacy() error {
+	body := w.legacyBody.Bytes()
+	w.w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 	if w.statusCode != 0 {
 		w.w.WriteHeader(w.statusCode)
 	} else {
 		code := new(codes.Code)
-		code.UnmarshalJSON([]byte(hdr.Get("Grpc-Status")))
+		code.UnmarshalJSON([]byte(w.w.Header().Get("Grpc-Status")))
 		w.w.WriteHeader(fromGrpcToStatus(*code))
 	}
-	_, err := w.w.Write(body)
-	if err != nil {
+	if _, err := w.w.Write(body); err != nil {
 		return err
 	}
-	if flusher, _ := w.w.(http.Flusher); flusher != nil {
-		flusher.Flush()
-	}
+	w.flushLocked()
 	return nil
 }
 
+// parseTrailerFrame extracts the gRPC status and message from the payload of a gRPC-Web trailer frame.
+func parseTrailerFrame(payload []byte) (codes.Code, string) {
+	status, message := codes.Unknown, ""
+	for _, line := range splitTrailerLines(payload) {
+		key, value, ok := cutTrailerLine(line)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "grpc-status":
+			if n, err := strconv.Atoi(value); err == nil {
+				status = codes.Code(n)
+			}
+		case "grpc-message":
+			message = value
+		}
+	}
+	return status, message
+}
+
+// collectTrailers gathers the trailers that were announced via the `Trailer` header (captured in announced) and
+// those set using the `http.TrailerPrefix` convention, as a fresh Header ready to be rendered as a trailer frame.
+func collectTrailers(header http.Header, announced []string) http.Header {
+	trailers := make(http.Header)
+	for _, name := range announced {
+		if values := header.Values(name); len(values) > 0 {
+			trailers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	for key, values := range header {
+		if !strings.HasPrefix(key, http.TrailerPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, http.TrailerPrefix)
+		trailers[http.CanonicalHeaderKey(name)] = values
+	}
+	return trailers
+}
+
+// encodeTrailerBlock renders trailers as the HTTP/1.1-style `key: value\r\n` block carried by a gRPC-Web trailer
+// frame.
+func encodeTrailerBlock(trailers http.Header) []byte {
+	var buf bytes.Buffer
+	for key, values := range trailers {
+		for _, value := range values {
+			fmt.Fprintf(&buf, "%s: %s\r\n", strings.ToLower(key), value)
+		}
+	}
+	return buf.Bytes()
+}
+
+func contentTypeForFormat(format StreamFormat) string {
+	switch format {
+	case FormatSSE:
+		return "text/event-stream"
+	case FormatJSONSeq:
+		return "application/json-seq"
+	case FormatGRPCWeb:
+		return "application/grpc-web+json"
+	case FormatGRPCWebText:
+		return "application/grpc-web-text"
+	case FormatJSONLegacy:
+		return "application/json"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// fromGrpcToStatus maps a gRPC status code to an HTTP status code, following the canonical mapping used by
+// grpc-gateway.
 func fromGrpcToStatus(code codes.Code) (statusCode int) {
 	switch code {
 	case codes.OK:
 		statusCode = 200
+	case codes.Canceled:
+		statusCode = 499
+	case codes.Unknown:
+		statusCode = 500
 	case codes.InvalidArgument:
 		statusCode = 400
+	case codes.DeadlineExceeded:
+		statusCode = 504
 	case codes.NotFound:
 		statusCode = 404
+	case codes.AlreadyExists:
+		statusCode = 409
 	case codes.PermissionDenied:
 		statusCode = 403
 	case codes.Unauthenticated:
 		statusCode = 401
 	case codes.ResourceExhausted:
 		statusCode = 429
+	case codes.FailedPrecondition:
+		statusCode = 400
+	case codes.Aborted:
+		statusCode = 409
+	case codes.OutOfRange:
+		statusCode = 400
 	case codes.Unimplemented:
 		statusCode = 501
-	case codes.Aborted:
-		statusCode = 444
-	case codes.DeadlineExceeded:
-		statusCode = 504
+	case codes.Internal:
+		statusCode = 500
 	case codes.Unavailable:
 		statusCode = 503
-	case codes.FailedPrecondition:
-		statusCode = 428
-	case codes.Unknown:
+	case codes.DataLoss:
 		statusCode = 500
 	default:
 		statusCode = 500
@@ -0,0 +1,145 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/longXboy/go-grpc-http1/internal/grpcweb"
+
+	"google.golang.org/grpc"
+)
+
+// Handler is an http.Handler that maps RESTful requests onto a gRPC server's methods via their registered Routes,
+// turning the module into a drop-in replacement for running grpc-gateway as a separate process.
+type Handler struct {
+	server *grpc.Server
+	routes []Route
+}
+
+// NewHandler builds a Handler that dispatches matching requests to server. Use RoutesFromServer to derive routes
+// from the `google.api.http` annotations of server's registered services, or construct Routes directly (e.g. for
+// tests, or where annotations aren't available).
+func NewHandler(server *grpc.Server, routes []Route) *Handler {
+	return &Handler{server: server, routes: routes}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, vars, ok := h.matchRoute(r.Method, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := buildRequestMessage(r, route.Rule, vars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, err := grpcweb.NewJsonReader(ioutil.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	grpcReq := r.Clone(r.Context())
+	grpcReq.Method = http.MethodPost
+	grpcReq.URL = &url.URL{Path: route.FullMethod}
+	grpcReq.RequestURI = route.FullMethod
+	grpcReq.Header = r.Header.Clone()
+	grpcReq.Header.Set("Content-Type", "application/grpc")
+	grpcReq.Body = reader
+	grpcReq.ContentLength = -1
+
+	// Routes registered by this package serve unary REST endpoints (the whole point of being a drop-in
+	// replacement for grpc-gateway), so render the response as a single JSON body with the gRPC status mapped
+	// onto the HTTP status, rather than the streaming NDJSON default.
+	respWriter, finalize := grpcweb.NewJsonStreamWriter(w, grpcweb.WithStreamFormat(grpcweb.FormatJSONLegacy))
+	h.server.ServeHTTP(respWriter, grpcReq)
+	_ = finalize()
+}
+
+func (h *Handler) matchRoute(method, path string) (Route, map[string]string, bool) {
+	for _, route := range h.routes {
+		if vars, ok := route.match(method, path); ok {
+			return route, vars, true
+		}
+	}
+	return Route{}, nil, false
+}
+
+// buildRequestMessage assembles the JSON request message for rule: path variables and query parameters populate
+// named fields (including nested "foo.bar" paths), and rule.Body selects which part, if any, of the request JSON
+// body is merged in verbatim.
+func buildRequestMessage(r *http.Request, rule HttpRule, vars map[string]string) ([]byte, error) {
+	msg := map[string]interface{}{}
+
+	switch rule.Body {
+	case "*":
+		if err := mergeRequestBody(r, msg); err != nil {
+			return nil, err
+		}
+	case "":
+		// No part of the request body becomes the message; it is populated purely from path/query parameters.
+	default:
+		sub := map[string]interface{}{}
+		if err := mergeRequestBody(r, sub); err != nil {
+			return nil, err
+		}
+		setField(msg, strings.Split(rule.Body, "."), sub)
+	}
+
+	for name, value := range vars {
+		setField(msg, strings.Split(name, "."), value)
+	}
+
+	if rule.Body != "*" {
+		for name, values := range r.URL.Query() {
+			if len(values) == 0 {
+				continue
+			}
+			if _, bound := vars[name]; bound {
+				continue
+			}
+			if len(values) == 1 {
+				setField(msg, strings.Split(name, "."), values[0])
+			} else {
+				setField(msg, strings.Split(name, "."), values)
+			}
+		}
+	}
+
+	return json.Marshal(msg)
+}
+
+func mergeRequestBody(r *http.Request, dst map[string]interface{}) error {
+	if r.Body == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &dst)
+}
+
+// setField assigns value at the nested field path within msg, creating intermediate objects as necessary.
+func setField(msg map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := msg[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			msg[key] = next
+		}
+		msg = next
+	}
+	msg[path[len(path)-1]] = value
+}
@@ -0,0 +1,106 @@
+package transcode
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildRequestMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		rule HttpRule
+		vars map[string]string
+		url  string
+		body string
+		want map[string]interface{}
+	}{
+		{
+			name: "path vars only, no body",
+			rule: HttpRule{Body: ""},
+			vars: map[string]string{"id": "42"},
+			url:  "/v1/users/42",
+			want: map[string]interface{}{"id": "42"},
+		},
+		{
+			name: "nested path var",
+			rule: HttpRule{Body: ""},
+			vars: map[string]string{"user.id": "42"},
+			url:  "/v1/42/profile",
+			want: map[string]interface{}{"user": map[string]interface{}{"id": "42"}},
+		},
+		{
+			name: "whole body merged",
+			rule: HttpRule{Body: "*"},
+			vars: map[string]string{"id": "42"},
+			url:  "/v1/users/42",
+			body: `{"name":"alice"}`,
+			want: map[string]interface{}{"id": "42", "name": "alice"},
+		},
+		{
+			name: "sub-field body merged",
+			rule: HttpRule{Body: "user"},
+			vars: map[string]string{"id": "42"},
+			url:  "/v1/users/42",
+			body: `{"name":"alice"}`,
+			want: map[string]interface{}{"id": "42", "user": map[string]interface{}{"name": "alice"}},
+		},
+		{
+			name: "query params merged, bound var excluded",
+			rule: HttpRule{Body: ""},
+			vars: map[string]string{"id": "42"},
+			url:  "/v1/users/42?id=99&filter=active",
+			want: map[string]interface{}{"id": "42", "filter": "active"},
+		},
+		{
+			name: "repeated query param becomes a slice",
+			rule: HttpRule{Body: ""},
+			vars: map[string]string{},
+			url:  "/v1/users?tag=a&tag=b",
+			want: map[string]interface{}{"tag": []interface{}{"a", "b"}},
+		},
+		{
+			name: "query params ignored when body is *",
+			rule: HttpRule{Body: "*"},
+			vars: map[string]string{},
+			url:  "/v1/users?tag=a",
+			body: `{"name":"alice"}`,
+			want: map[string]interface{}{"name": "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			got, err := buildRequestMessage(r, tt.rule, tt.vars)
+			if err != nil {
+				t.Fatalf("buildRequestMessage: %v", err)
+			}
+			var gotMsg map[string]interface{}
+			if err := json.Unmarshal(got, &gotMsg); err != nil {
+				t.Fatalf("unmarshal result: %v", err)
+			}
+			if !reflect.DeepEqual(gotMsg, tt.want) {
+				t.Errorf("buildRequestMessage() = %#v, want %#v", gotMsg, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetField(t *testing.T) {
+	msg := map[string]interface{}{}
+	setField(msg, []string{"a", "b", "c"}, "v")
+	want := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": map[string]interface{}{
+				"c": "v",
+			},
+		},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("setField() = %#v, want %#v", msg, want)
+	}
+}
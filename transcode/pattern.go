@@ -0,0 +1,135 @@
+package transcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// segment is a single element of a compiled path template: either a literal path segment, or a variable bound to
+// a (possibly nested, e.g. "foo.bar") proto field path. A variable segment may be greedy (wildcard), in which case
+// it requires literalPrefix to match the path segments immediately preceding it and then binds to the remainder of
+// the path (literalPrefix included), joined by "/", rather than just one segment.
+type segment struct {
+	literal       string
+	variable      string
+	wildcard      bool
+	literalPrefix []string
+}
+
+// pattern is a compiled grpc-gateway style path template, e.g. "/v1/users/{id}" or "/v1/{parent.id}/users". A
+// variable may carry a "=sub/pattern" suffix whose trailing "**" component (e.g. "/v1/{name=shelves/**}") makes
+// the variable greedy, consuming the rest of the path including any literal components before the "**";
+// "{name=*}" binds a single segment like "{name}"; any other sub-pattern without a trailing "**" is matched as
+// independent literal path segments instead, with no binding.
+type pattern struct {
+	segments []segment
+}
+
+// splitTemplateSegments splits template on top-level "/" characters, i.e. ones not nested inside a "{...}"
+// variable, so that a "=sub/pattern" suffix containing its own slashes stays part of the same template segment.
+func splitTemplateSegments(template string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, r := range template {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, template[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, template[start:])
+}
+
+// compilePattern parses a path template into a pattern that can be matched against incoming request paths.
+func compilePattern(template string) (pattern, error) {
+	template = strings.Trim(template, "/")
+	var p pattern
+	for _, part := range splitTemplateSegments(template) {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			p.segments = append(p.segments, segment{literal: part})
+			continue
+		}
+		inner := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+		name, subPattern := inner, ""
+		if idx := strings.IndexByte(inner, '='); idx >= 0 {
+			name, subPattern = inner[:idx], inner[idx+1:]
+		}
+		if name == "" {
+			return pattern{}, fmt.Errorf("transcode: empty path variable in template %q", template)
+		}
+		if subPattern == "" || subPattern == "*" {
+			p.segments = append(p.segments, segment{variable: name})
+			continue
+		}
+
+		subSegments := strings.Split(subPattern, "/")
+		wildcardIdx := -1
+		for i, sub := range subSegments {
+			if sub == "**" {
+				wildcardIdx = i
+				break
+			}
+		}
+		if wildcardIdx == -1 {
+			// No "**": each component is matched as an independent literal path segment, not bound to name.
+			for _, sub := range subSegments {
+				p.segments = append(p.segments, segment{literal: sub})
+			}
+			continue
+		}
+		if wildcardIdx != len(subSegments)-1 {
+			return pattern{}, fmt.Errorf(
+				"transcode: %q must be the final component of variable %q's sub-pattern in template %q",
+				"**", name, template)
+		}
+		p.segments = append(p.segments, segment{
+			variable:      name,
+			wildcard:      true,
+			literalPrefix: subSegments[:wildcardIdx],
+		})
+	}
+	return p, nil
+}
+
+// match attempts to match pathSegments (the request path, already split on "/") against the pattern, returning the
+// variables bound by proto field path on success. A wildcard segment first requires its literalPrefix to match the
+// path segments immediately ahead of it, then binds its variable to everything from there to the end of the path.
+func (p pattern) match(pathSegments []string) (map[string]string, bool) {
+	vars := make(map[string]string, len(p.segments))
+	i := 0
+	for _, seg := range p.segments {
+		if seg.wildcard {
+			if len(pathSegments)-i < len(seg.literalPrefix) {
+				return nil, false
+			}
+			for k, lit := range seg.literalPrefix {
+				if pathSegments[i+k] != lit {
+					return nil, false
+				}
+			}
+			vars[seg.variable] = strings.Join(pathSegments[i:], "/")
+			i = len(pathSegments)
+			break
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		if seg.variable != "" {
+			vars[seg.variable] = pathSegments[i]
+		} else if seg.literal != pathSegments[i] {
+			return nil, false
+		}
+		i++
+	}
+	if i != len(pathSegments) {
+		return nil, false
+	}
+	return vars, true
+}
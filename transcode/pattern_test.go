@@ -0,0 +1,107 @@
+package transcode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		path     string
+		wantVars map[string]string
+		wantOK   bool
+	}{
+		{
+			name:     "literal only",
+			template: "/v1/users",
+			path:     "/v1/users",
+			wantVars: map[string]string{},
+			wantOK:   true,
+		},
+		{
+			name:     "single variable",
+			template: "/v1/users/{id}",
+			path:     "/v1/users/42",
+			wantVars: map[string]string{"id": "42"},
+			wantOK:   true,
+		},
+		{
+			name:     "nested field variable",
+			template: "/v1/{user.id}/profile",
+			path:     "/v1/42/profile",
+			wantVars: map[string]string{"user.id": "42"},
+			wantOK:   true,
+		},
+		{
+			name:     "variable with sub-pattern suffix",
+			template: "/v1/{name=*}",
+			path:     "/v1/shelves",
+			wantVars: map[string]string{"name": "shelves"},
+			wantOK:   true,
+		},
+		{
+			name:     "no match on differing literal",
+			template: "/v1/users/{id}",
+			path:     "/v2/users/42",
+			wantVars: nil,
+			wantOK:   false,
+		},
+		{
+			name:     "no match on differing segment count",
+			template: "/v1/users/{id}",
+			path:     "/v1/users/42/extra",
+			wantVars: nil,
+			wantOK:   false,
+		},
+		{
+			name:     "trailing wildcard captures the rest of the path",
+			template: "/v1/{name=shelves/**}",
+			path:     "/v1/shelves/5/books/9",
+			wantVars: map[string]string{"name": "shelves/5/books/9"},
+			wantOK:   true,
+		},
+		{
+			name:     "trailing wildcard still requires its literal prefix",
+			template: "/v1/{name=shelves/**}",
+			path:     "/v1/boxes/5",
+			wantVars: nil,
+			wantOK:   false,
+		},
+		{
+			name:     "bare wildcard captures the rest of the path",
+			template: "/v1/{name=**}",
+			path:     "/v1/a/b/c",
+			wantVars: map[string]string{"name": "a/b/c"},
+			wantOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := compilePattern(tt.template)
+			if err != nil {
+				t.Fatalf("compilePattern(%q): %v", tt.template, err)
+			}
+			segments := strings.Split(strings.Trim(tt.path, "/"), "/")
+			vars, ok := p.match(segments)
+			if ok != tt.wantOK {
+				t.Fatalf("match() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				return
+			}
+			if !reflect.DeepEqual(vars, tt.wantVars) {
+				t.Errorf("match() vars = %v, want %v", vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestCompilePatternEmptyVariable(t *testing.T) {
+	if _, err := compilePattern("/v1/{}"); err == nil {
+		t.Fatal("compilePattern() with empty variable name should error")
+	}
+}
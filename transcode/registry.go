@@ -0,0 +1,86 @@
+package transcode
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RoutesFromServer inspects every service registered with server via server.GetServiceInfo and returns the Routes
+// declared by `google.api.http` annotations on their methods, resolved from the global proto registry. Methods
+// without an annotation are skipped.
+func RoutesFromServer(server *grpc.Server) ([]Route, error) {
+	var routes []Route
+	for serviceName, info := range server.GetServiceInfo() {
+		filePath, _ := info.Metadata.(string)
+		file, err := protoregistry.GlobalFiles.FindFileByPath(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("transcode: resolving file descriptor for service %q: %w", serviceName, err)
+		}
+		service, err := findService(file, serviceName)
+		if err != nil {
+			return nil, err
+		}
+
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			rule, ok := httpRuleFromMethod(method)
+			if !ok {
+				continue
+			}
+			fullMethod := fmt.Sprintf("/%s/%s", serviceName, method.Name())
+			route, err := NewRoute(fullMethod, rule)
+			if err != nil {
+				return nil, fmt.Errorf("transcode: %s: %w", fullMethod, err)
+			}
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+func findService(file protoreflect.FileDescriptor, name string) (protoreflect.ServiceDescriptor, error) {
+	services := file.Services()
+	for i := 0; i < services.Len(); i++ {
+		if string(services.Get(i).FullName()) == name {
+			return services.Get(i), nil
+		}
+	}
+	return nil, fmt.Errorf("transcode: service %q not found in its own file descriptor", name)
+}
+
+// httpRuleFromMethod extracts the `google.api.http` option from a method, if present, translating its primary
+// binding into an HttpRule. additional_bindings are not currently supported.
+func httpRuleFromMethod(method protoreflect.MethodDescriptor) (HttpRule, bool) {
+	opts := method.Options()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return HttpRule{}, false
+	}
+	rule, _ := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	if rule == nil {
+		return HttpRule{}, false
+	}
+
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return HttpRule{Method: http.MethodGet, Path: p.Get, Body: rule.GetBody()}, true
+	case *annotations.HttpRule_Put:
+		return HttpRule{Method: http.MethodPut, Path: p.Put, Body: rule.GetBody()}, true
+	case *annotations.HttpRule_Post:
+		return HttpRule{Method: http.MethodPost, Path: p.Post, Body: rule.GetBody()}, true
+	case *annotations.HttpRule_Delete:
+		return HttpRule{Method: http.MethodDelete, Path: p.Delete, Body: rule.GetBody()}, true
+	case *annotations.HttpRule_Patch:
+		return HttpRule{Method: http.MethodPatch, Path: p.Patch, Body: rule.GetBody()}, true
+	case *annotations.HttpRule_Custom:
+		return HttpRule{Method: p.Custom.GetKind(), Path: p.Custom.GetPath(), Body: rule.GetBody()}, true
+	default:
+		return HttpRule{}, false
+	}
+}
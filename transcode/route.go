@@ -0,0 +1,51 @@
+// Package transcode implements grpc-gateway style HTTP annotation routing on top of the JSON transcoder in
+// github.com/longXboy/go-grpc-http1/internal/grpcweb, so that RESTful URLs declared via `google.api.http`
+// annotations can be served directly off a `grpc.Server` without running grpc-gateway as a separate process.
+package transcode
+
+import "strings"
+
+// HttpRule describes a REST mapping for a single gRPC method, mirroring the fields of `google.api.HttpRule` that
+// this package understands.
+type HttpRule struct {
+	// Method is the HTTP method to match, e.g. "GET", "POST". An empty Method matches any.
+	Method string
+	// Path is the path template, e.g. "/v1/users/{id}". Path variables may address nested fields, e.g.
+	// "/v1/{user.id}". A variable may end with a "**" sub-pattern, e.g. "/v1/{name=shelves/**}", to greedily
+	// capture the rest of the path (including any literal components before the "**") as its value. "{name=*}" is
+	// the same as "{name}". Any other sub-pattern without a trailing "**" is matched as literal path segments
+	// instead, with no binding; a "*" component in it matches only a literal "*" segment, rather than following
+	// grpc-gateway's full single-segment-wildcard semantics.
+	Path string
+	// Body selects which part of the request JSON becomes the request message: "" sends no body fields at all
+	// (the message is populated purely from path variables and query parameters), "*" sends the entire JSON
+	// body, and a field name sends that nested field's JSON value as the body.
+	Body string
+}
+
+// Route binds a compiled HttpRule to the gRPC method it should dispatch to.
+type Route struct {
+	Rule       HttpRule
+	FullMethod string // e.g. "/pkg.Service/Method"
+
+	pattern pattern
+}
+
+// NewRoute compiles rule into a Route that dispatches to fullMethod.
+func NewRoute(fullMethod string, rule HttpRule) (Route, error) {
+	p, err := compilePattern(rule.Path)
+	if err != nil {
+		return Route{}, err
+	}
+	return Route{Rule: rule, FullMethod: fullMethod, pattern: p}, nil
+}
+
+// match reports whether r matches the given HTTP method and path, returning the path variables bound by proto
+// field path on success.
+func (r Route) match(method, path string) (map[string]string, bool) {
+	if r.Rule.Method != "" && !strings.EqualFold(r.Rule.Method, method) {
+		return nil, false
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return r.pattern.match(segments)
+}